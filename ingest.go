@@ -0,0 +1,125 @@
+//
+// This is the integration point between report-ingestion and the
+// optional pkg/publisher and pkg/metrics subsystems: the upload handler
+// calls IngestReport once it has read a submitted report's body, and
+// IngestReport runs ParsePuppetReport and, right after it succeeds,
+// updates the Prometheus registry and publishes the report - so every
+// report, regardless of which schema it was submitted as, gets a single
+// chance to do both.
+//
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/skx/puppet-summary/pkg/metrics"
+	"github.com/skx/puppet-summary/pkg/publisher"
+)
+
+//
+// reportPublisher is the optional AMQP fan-out configured via
+// ConfigurePublisher.  It is nil, and Publish becomes a no-op, until a
+// broker URL has been supplied.
+//
+var reportPublisher *publisher.Publisher
+
+//
+// ConfigurePublisher wires up the AMQP fan-out described by the
+// -amqp-url/-amqp-exchange/-amqp-routing-key-template flags.  Passing an
+// empty url leaves publishing disabled.
+//
+func ConfigurePublisher(url string, exchange string, routingKeyTemplate string, workers int) error {
+	if url == "" {
+		reportPublisher = nil
+		return nil
+	}
+
+	p, err := publisher.New(url, exchange, routingKeyTemplate, workers)
+	if err != nil {
+		return err
+	}
+
+	reportPublisher = p
+	return nil
+}
+
+//
+// IngestReport is the single entry point the upload handler calls once
+// it has read a report's body from the request: it parses the report,
+// records it against the Prometheus registry and, if an AMQP publisher
+// has been configured, fans it out to the exchange.
+//
+func IngestReport(content []byte) (PuppetReport, error) {
+	report, err := ParsePuppetReport(content)
+	if err != nil {
+		return report, err
+	}
+
+	recordMetrics(report)
+
+	if reportPublisher != nil {
+		reportPublisher.Publish(report)
+	}
+
+	return report, nil
+}
+
+//
+// recordMetrics updates the Prometheus series derived from report.
+// Counts that didn't parse as numbers (e.g. blank fields from a schema
+// that doesn't carry them) are simply left unreported, rather than
+// overwriting the existing gauge with a zero.
+//
+func recordMetrics(report PuppetReport) {
+	if seconds, err := strconv.ParseFloat(report.Runtime, 64); err == nil {
+		metrics.ObserveRuntime(report.Fqdn, report.Environment, seconds)
+	}
+
+	metrics.ObserveResourceCount(report.Fqdn, "changed", float64(len(report.ResourcesChanged)))
+	metrics.ObserveResourceCount(report.Fqdn, "failed", float64(len(report.ResourcesFailed)))
+	metrics.ObserveResourceCount(report.Fqdn, "skipped", float64(len(report.ResourcesSkipped)+len(report.ResourcesDependencyFailed)))
+	metrics.ObserveResourceCount(report.Fqdn, "ok", float64(len(report.ResourcesOK)))
+
+	if success, err := strconv.ParseFloat(report.EventsSuccess, 64); err == nil {
+		metrics.ObserveEvents(report.Fqdn, "success", success)
+	}
+	if failure, err := strconv.ParseFloat(report.EventsFailure, 64); err == nil {
+		metrics.ObserveEvents(report.Fqdn, "failure", failure)
+	}
+
+	if timestamp, err := parseReportTimestamp(report.At); err == nil {
+		metrics.ObserveLastRun(report.Fqdn, timestamp)
+	}
+}
+
+//
+// parseReportTimestamp parses the self-reported `at` time a report
+// carries - Puppet doesn't commit to a single layout across versions,
+// so a couple of the common ones are tried in turn.
+//
+func parseReportTimestamp(at string) (float64, error) {
+	layouts := []string{
+		time.RFC3339,
+		"2006-01-02T15:04:05.999999999Z07:00",
+		"2006-01-02 15:04:05 -0700",
+	}
+
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, at); err == nil {
+			return float64(t.Unix()), nil
+		}
+	}
+
+	return 0, errors.New("unrecognised report timestamp format")
+}
+
+//
+// RegisterMetricsHandler mounts the Prometheus /metrics endpoint on mux.
+//
+func RegisterMetricsHandler(mux *http.ServeMux) {
+	mux.Handle("/metrics", metrics.Handler())
+}
@@ -0,0 +1,83 @@
+//
+// This is a minimal HTTP front-end: enough to accept a Puppet report at
+// /upload/{fqdn} and feed it through IngestReport.  It intentionally
+// doesn't attempt to be the full puppet-summary server - no sqlite
+// store, no dashboard - it exists to give the AMQP publisher a real
+// place to be wired in via CLI flags.
+//
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/skx/puppet-summary/pkg/metrics"
+	"github.com/skx/puppet-summary/pkg/publisher"
+)
+
+func main() {
+	bind := flag.String("bind", "127.0.0.1:3001", "The address to bind our HTTP-server upon.")
+	amqpURL := flag.String("amqp-url", "", "AMQP broker URL to publish ingested reports to; publishing is disabled if empty.")
+	amqpExchange := flag.String("amqp-exchange", "", "AMQP exchange to publish ingested reports to.")
+	amqpRoutingKeyTemplate := flag.String("amqp-routing-key-template", publisher.DefaultRoutingKeyTemplate, "Go template, evaluated against each report, used as the AMQP routing-key.")
+	amqpWorkers := flag.Int("amqp-workers", 4, "Number of worker goroutines draining the AMQP publish queue.")
+	flag.Parse()
+
+	if err := ConfigurePublisher(*amqpURL, *amqpExchange, *amqpRoutingKeyTemplate, *amqpWorkers); err != nil {
+		log.Fatalf("failed to configure AMQP publisher: %s", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upload/", uploadHandler)
+	mux.HandleFunc("/expire/", expireHandler)
+	RegisterMetricsHandler(mux)
+
+	log.Fatal(http.ListenAndServe(*bind, mux))
+}
+
+//
+// uploadHandler accepts a Puppet report body at /upload/{fqdn} and feeds
+// it through IngestReport.
+//
+func uploadHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := IngestReport(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The last_run_summary.yaml layout carries no host of its own, so
+	// fall back to the fqdn the report was uploaded against.
+	if report.Fqdn == "" {
+		report.Fqdn = strings.TrimPrefix(r.URL.Path, "/upload/")
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+//
+// expireHandler drops every Prometheus series recorded for the fqdn
+// named at /expire/{fqdn}.  There's no sqlite-backed pruning job in
+// this tree to call metrics.Expire automatically, so it's exposed here
+// as an operator-triggered endpoint instead.
+//
+func expireHandler(w http.ResponseWriter, r *http.Request) {
+	fqdn := strings.TrimPrefix(r.URL.Path, "/expire/")
+	if fqdn == "" {
+		http.Error(w, "missing fqdn", http.StatusBadRequest)
+		return
+	}
+
+	metrics.Expire(fqdn)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,210 @@
+//
+// Package metrics exposes Prometheus metrics derived from ingested
+// Puppet reports.
+//
+// It is the natural evolution of the older scollector-style approach of
+// running a sidecar which scrapes `last_run_summary.yaml`: instead
+// puppet-summary itself becomes the source of truth for Prometheus,
+// updating its registry as each report is parsed rather than reading it
+// back off disk.
+//
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+//
+// Runtime is the duration, in seconds, that a puppet-run took to
+// complete, labelled by the node that ran it and the environment it
+// ran in.
+//
+var Runtime = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "puppet_report_runtime_seconds",
+		Help: "The duration, in seconds, of the most recently ingested puppet-run.",
+	},
+	[]string{"fqdn", "environment"},
+)
+
+//
+// ResourcesTotal is a count of resources in each state - changed,
+// failed, skipped, ok - from the most recently ingested report for a
+// node.
+//
+var ResourcesTotal = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "puppet_report_resources_total",
+		Help: "Count of resources in each state from the most recently ingested puppet-run.",
+	},
+	[]string{"fqdn", "state"},
+)
+
+//
+// LastRunTimestamp is the time, in seconds since the epoch, that a
+// node's most recently ingested report was generated.
+//
+var LastRunTimestamp = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "puppet_report_last_run_timestamp_seconds",
+		Help: "Timestamp of the most recently ingested puppet-run for a node.",
+	},
+	[]string{"fqdn"},
+)
+
+//
+// EventsTotal counts successful and failed events across all ingested
+// reports for a node.
+//
+var EventsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "puppet_report_events_total",
+		Help: "Count of puppet events, by result, seen for a node.",
+	},
+	[]string{"fqdn", "result"},
+)
+
+//
+// ParseErrorsTotal counts failures to parse an incoming report, broken
+// down by the parsing stage (parseHost, parseEnvironment, etc.) that
+// rejected it.
+//
+var ParseErrorsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "puppet_report_parse_errors_total",
+		Help: "Count of report-parsing failures, by stage.",
+	},
+	[]string{"stage"},
+)
+
+func init() {
+	prometheus.MustRegister(Runtime)
+	prometheus.MustRegister(ResourcesTotal)
+	prometheus.MustRegister(LastRunTimestamp)
+	prometheus.MustRegister(EventsTotal)
+	prometheus.MustRegister(ParseErrorsTotal)
+}
+
+//
+// Handler returns the http.Handler to be mounted at /metrics.
+//
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+//
+// ParseError records a parse-failure at the given stage, e.g.
+// "parseHost" or "parseEnvironment".
+//
+func ParseError(stage string) {
+	ParseErrorsTotal.WithLabelValues(stage).Inc()
+}
+
+//
+// nodeLabels remembers every distinct label-combination we've emitted
+// for a given fqdn, so Expire can delete exactly those series later.
+//
+// The `github.com/prometheus/client_golang` version this module is
+// pinned to doesn't offer `DeletePartialMatch` - that only arrived in
+// much later releases - so the only way to drop "every series for this
+// fqdn" is to call `Delete` with each full label set we actually used.
+//
+type nodeLabels struct {
+	environment string
+	states      map[string]bool
+	results     map[string]bool
+}
+
+var (
+	nodesMu sync.Mutex
+	nodes   = map[string]*nodeLabels{}
+)
+
+func node(fqdn string) *nodeLabels {
+	n, ok := nodes[fqdn]
+	if !ok {
+		n = &nodeLabels{states: map[string]bool{}, results: map[string]bool{}}
+		nodes[fqdn] = n
+	}
+	return n
+}
+
+//
+// ObserveRuntime records the duration of a puppet-run for fqdn.
+//
+func ObserveRuntime(fqdn string, environment string, seconds float64) {
+	Runtime.WithLabelValues(fqdn, environment).Set(seconds)
+
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	node(fqdn).environment = environment
+}
+
+//
+// ObserveResourceCount records, for fqdn, how many resources ended up in
+// the given state ("changed", "failed", "skipped" or "ok").
+//
+func ObserveResourceCount(fqdn string, state string, count float64) {
+	ResourcesTotal.WithLabelValues(fqdn, state).Set(count)
+
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	node(fqdn).states[state] = true
+}
+
+//
+// ObserveLastRun records the timestamp of the most recently ingested
+// report for fqdn.
+//
+func ObserveLastRun(fqdn string, timestamp float64) {
+	LastRunTimestamp.WithLabelValues(fqdn).Set(timestamp)
+
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	node(fqdn)
+}
+
+//
+// ObserveEvents increments the event counter for fqdn for the given
+// result ("success" or "failure").
+//
+func ObserveEvents(fqdn string, result string, count float64) {
+	EventsTotal.WithLabelValues(fqdn, result).Add(count)
+
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	node(fqdn).results[result] = true
+}
+
+//
+// Expire removes every series associated with fqdn that we've recorded
+// via the Observe* functions above, so stale gauges don't linger forever.
+// Nothing in this tree calls it automatically when a node goes away; it
+// runs only when main.go's expireHandler is asked to expire an fqdn.
+//
+func Expire(fqdn string) {
+	nodesMu.Lock()
+	n, ok := nodes[fqdn]
+	if ok {
+		delete(nodes, fqdn)
+	}
+	nodesMu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	Runtime.Delete(prometheus.Labels{"fqdn": fqdn, "environment": n.environment})
+	LastRunTimestamp.Delete(prometheus.Labels{"fqdn": fqdn})
+
+	for state := range n.states {
+		ResourcesTotal.Delete(prometheus.Labels{"fqdn": fqdn, "state": state})
+	}
+	for result := range n.results {
+		EventsTotal.Delete(prometheus.Labels{"fqdn": fqdn, "result": result})
+	}
+}
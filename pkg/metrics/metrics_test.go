@@ -0,0 +1,37 @@
+package metrics
+
+import "testing"
+
+// TestExpireClearsNodeBookkeeping confirms Expire forgets the
+// label-combinations it tracked for a fqdn via the Observe* functions,
+// so a second Expire of the same fqdn - e.g. a duplicate prune - is a
+// harmless no-op rather than operating on stale state.
+func TestExpireClearsNodeBookkeeping(t *testing.T) {
+	const fqdn = "expire-test.example.com"
+
+	ObserveRuntime(fqdn, "production", 12.3)
+	ObserveResourceCount(fqdn, "changed", 1)
+	ObserveResourceCount(fqdn, "failed", 0)
+	ObserveLastRun(fqdn, 1700000000)
+	ObserveEvents(fqdn, "success", 1)
+
+	nodesMu.Lock()
+	_, tracked := nodes[fqdn]
+	nodesMu.Unlock()
+	if !tracked {
+		t.Fatalf("fqdn %q not tracked in nodes after Observe* calls", fqdn)
+	}
+
+	Expire(fqdn)
+
+	nodesMu.Lock()
+	_, stillTracked := nodes[fqdn]
+	nodesMu.Unlock()
+	if stillTracked {
+		t.Errorf("fqdn %q still tracked in nodes after Expire", fqdn)
+	}
+
+	// Expiring an already-expired (or never-observed) fqdn must not
+	// panic or otherwise misbehave.
+	Expire(fqdn)
+}
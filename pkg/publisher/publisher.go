@@ -0,0 +1,298 @@
+//
+// Package publisher fans parsed Puppet reports out to an AMQP exchange,
+// so that downstream systems - alerting, CMDB sync, long-term archival -
+// can consume them without polling the sqlite store or re-parsing YAML
+// themselves.
+//
+// Publishing is best-effort: a slow, or unreachable, broker must never
+// stall report ingestion, so every report is queued onto a buffered
+// channel and a small pool of worker goroutines drains it in the
+// background.  If the channel is full the report is dropped, and the
+// drop is counted rather than blocking the caller.
+//
+package publisher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"text/template"
+
+	"github.com/streadway/amqp"
+)
+
+//
+// Publisher publishes JSON-encoded reports to a configured AMQP
+// exchange.
+//
+type Publisher struct {
+
+	//
+	// url is the AMQP broker to connect to.
+	//
+	url string
+
+	//
+	// exchange is the name of the exchange messages are published to.
+	//
+	exchange string
+
+	//
+	// routingKey is the parsed template used to compute the routing-key
+	// for each message, evaluated against the report being published.
+	//
+	routingKey *template.Template
+
+	//
+	// queue is the buffered channel of pending messages.
+	//
+	queue chan interface{}
+
+	//
+	// conn and channel are the underlying AMQP connection/channel.
+	//
+	// They are re-established lazily by the worker goroutines, so a
+	// broker which isn't reachable at start-up doesn't stop us
+	// accepting reports.
+	//
+	// connMu guards conn/channel/generation, since several worker
+	// goroutines may race to establish the first connection or to
+	// reset it after a publish failure.
+	//
+	// generation is bumped every time conn/channel change - either by
+	// a fresh dial or by a reset - so a worker that observed a channel
+	// at generation N can tell, after a failed publish, whether some
+	// other worker has already replaced it: if the current generation
+	// has moved on, its reset is a no-op, because it would otherwise
+	// tear down a connection it never used.
+	connMu     sync.Mutex
+	conn       *amqp.Connection
+	channel    *amqp.Channel
+	generation uint64
+
+	//
+	// Counters, updated atomically, so callers can expose them via
+	// whatever metrics system they like.
+	//
+	queued    uint64
+	published uint64
+	dropped   uint64
+}
+
+//
+// DefaultRoutingKeyTemplate is used when the caller doesn't supply their
+// own routing-key template.
+//
+const DefaultRoutingKeyTemplate = "puppet.{{.Environment}}.{{.State}}"
+
+//
+// DefaultQueueSize is the size of the buffered channel sat in front of
+// the AMQP workers.  Once it is full new reports are dropped rather than
+// blocking the submitter.
+//
+const DefaultQueueSize = 1000
+
+//
+// New creates a Publisher which will publish to the given AMQP exchange,
+// using routingKeyTemplate (a Go template, evaluated against each
+// report) to compute the per-message routing-key.
+//
+// workers background goroutines are started to drain the internal
+// queue; the AMQP connection itself isn't opened until the first
+// message needs to be sent.
+//
+func New(url string, exchange string, routingKeyTemplate string, workers int) (*Publisher, error) {
+	if routingKeyTemplate == "" {
+		routingKeyTemplate = DefaultRoutingKeyTemplate
+	}
+
+	tmpl, err := template.New("routing-key").Parse(routingKeyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid routing-key template: %s", err)
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &Publisher{
+		url:        url,
+		exchange:   exchange,
+		routingKey: tmpl,
+		queue:      make(chan interface{}, DefaultQueueSize),
+	}
+
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+
+	return p, nil
+}
+
+//
+// Publish queues report to be published to AMQP.  It never blocks: if
+// the internal queue is full the report is dropped and Dropped() will
+// reflect that.
+//
+func (p *Publisher) Publish(report interface{}) {
+	select {
+	case p.queue <- report:
+		atomic.AddUint64(&p.queued, 1)
+	default:
+		atomic.AddUint64(&p.dropped, 1)
+	}
+}
+
+//
+// Queued returns the number of reports that have been accepted onto the
+// internal queue.
+//
+func (p *Publisher) Queued() uint64 {
+	return atomic.LoadUint64(&p.queued)
+}
+
+//
+// Published returns the number of reports successfully published to
+// AMQP.
+//
+func (p *Publisher) Published() uint64 {
+	return atomic.LoadUint64(&p.published)
+}
+
+//
+// Dropped returns the number of reports which were discarded, either
+// because the internal queue was full or because publishing to AMQP
+// failed.
+//
+func (p *Publisher) Dropped() uint64 {
+	return atomic.LoadUint64(&p.dropped)
+}
+
+//
+// worker drains the queue, publishing each report to AMQP in turn.
+//
+func (p *Publisher) worker() {
+	for report := range p.queue {
+		if err := p.publish(report); err != nil {
+			atomic.AddUint64(&p.dropped, 1)
+			continue
+		}
+		atomic.AddUint64(&p.published, 1)
+	}
+}
+
+//
+// publish opens the AMQP channel, lazily, and sends a single message.
+// On failure the cached channel/connection is dropped, so the next
+// call to channelFor redials rather than repeatedly handing back a
+// connection the broker has already closed on us.
+//
+func (p *Publisher) publish(report interface{}) error {
+	ch, generation, err := p.channelFor()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	var key bytes.Buffer
+	if err := p.routingKey.Execute(&key, report); err != nil {
+		return err
+	}
+
+	err = ch.Publish(
+		p.exchange,   // exchange
+		key.String(), // routing key
+		false,        // mandatory
+		false,        // immediate
+		amqp.Publishing{
+			ContentType: "application/json",
+			Body:        body,
+		},
+	)
+	if err != nil {
+		p.resetConnection(generation)
+	}
+	return err
+}
+
+//
+// channelFor returns our AMQP channel, (re-)connecting if necessary,
+// along with the generation it was handed out at.
+//
+// Workers share a single connection/channel, guarded by connMu, since
+// several worker goroutines may race to establish the first connection.
+//
+func (p *Publisher) channelFor() (*amqp.Channel, uint64, error) {
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+
+	if p.channel != nil {
+		return p.channel, p.generation, nil
+	}
+
+	conn, err := amqp.Dial(p.url)
+	if err != nil {
+		return nil, p.generation, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, p.generation, err
+	}
+
+	p.conn = conn
+	p.channel = ch
+	p.generation++
+	return ch, p.generation, nil
+}
+
+//
+// resetConnection drops the cached channel/connection so the next
+// publish attempt redials, instead of reusing one the broker has
+// already closed on us - but only if generation still matches the one
+// the caller observed via channelFor.  If it doesn't, some other
+// worker has already reset or re-established the connection, and
+// tearing it down here would needlessly drop a connection this caller
+// never used.
+//
+func (p *Publisher) resetConnection(generation uint64) {
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+
+	if p.generation != generation {
+		return
+	}
+
+	if p.channel != nil {
+		p.channel.Close()
+	}
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.channel = nil
+	p.conn = nil
+	p.generation++
+}
+
+//
+// Close shuts down the AMQP connection, if one is open.
+//
+func (p *Publisher) Close() error {
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+
+	if p.channel != nil {
+		p.channel.Close()
+	}
+	if p.conn != nil {
+		return p.conn.Close()
+	}
+	return nil
+}
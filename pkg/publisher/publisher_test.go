@@ -0,0 +1,63 @@
+package publisher
+
+import "testing"
+
+// TestPublishDropsWhenQueueFull confirms Publish never blocks the
+// caller: once the internal queue is saturated, further reports are
+// dropped and counted rather than stalling report ingestion.
+func TestPublishDropsWhenQueueFull(t *testing.T) {
+	p := &Publisher{
+		queue: make(chan interface{}, 2),
+	}
+
+	p.Publish("one")
+	p.Publish("two")
+	p.Publish("three")
+
+	if got := p.Queued(); got != 2 {
+		t.Errorf("Queued() = %d, want 2", got)
+	}
+	if got := p.Dropped(); got != 1 {
+		t.Errorf("Dropped() = %d, want 1", got)
+	}
+}
+
+// TestResetConnectionIgnoresStaleGeneration confirms resetConnection is
+// a no-op when passed a generation older than the Publisher's current
+// one - otherwise a worker that lost a race against a concurrent
+// reconnect would tear down a connection it never actually used.
+func TestResetConnectionIgnoresStaleGeneration(t *testing.T) {
+	p := &Publisher{
+		queue:      make(chan interface{}, 1),
+		generation: 5,
+	}
+
+	p.resetConnection(4)
+
+	if p.generation != 5 {
+		t.Errorf("generation = %d, want 5 (stale reset must not bump it)", p.generation)
+	}
+}
+
+// TestResetConnectionAppliesCurrentGeneration confirms resetConnection
+// does reset, and bumps the generation counter, when the caller's
+// generation still matches - the connection it observed really is the
+// one that needs tearing down.
+func TestResetConnectionAppliesCurrentGeneration(t *testing.T) {
+	p := &Publisher{
+		queue:      make(chan interface{}, 1),
+		generation: 5,
+	}
+
+	p.resetConnection(5)
+
+	if p.generation != 6 {
+		t.Errorf("generation = %d, want 6", p.generation)
+	}
+	if p.channel != nil {
+		t.Errorf("channel = %v, want nil", p.channel)
+	}
+	if p.conn != nil {
+		t.Errorf("conn = %v, want nil", p.conn)
+	}
+}
@@ -12,13 +12,16 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha1"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
 	"regexp"
 	"strings"
 
+	"github.com/skx/puppet-summary/pkg/metrics"
 	"github.com/smallfish/simpleyaml"
 )
 
@@ -32,6 +35,20 @@ type Resource struct {
 	Type string
 	File string
 	Line string
+
+	//
+	// Reason records why the resource ended up in a non-OK state - for
+	// a skipped resource this distinguishes "schedule metadata said not
+	// to run this" from "a dependency of mine failed".
+	//
+	Reason string
+
+	//
+	// Corrective is true if Puppet reports this resource as a
+	// corrective change, i.e. it fixed unexpected configuration drift
+	// rather than applying an intentional catalog change.
+	//
+	Corrective bool
 }
 
 //
@@ -76,6 +93,43 @@ type PuppetReport struct {
 	Total   string
 	Skipped string
 
+	//
+	// OutOfSync, Restarted, ScheduledCount, FailedToRestart, and
+	// CorrectiveChange mirror the `resources` block of the summary,
+	// which is richer than the handful of counts we kept above.
+	//
+	OutOfSync        string
+	Restarted        string
+	ScheduledCount   string
+	FailedToRestart  string
+	CorrectiveChange string
+
+	//
+	// EventsSuccess, EventsFailure and EventsTotal come from the
+	// `events` block of the summary.
+	//
+	EventsSuccess string
+	EventsFailure string
+	EventsTotal   string
+
+	//
+	// ConfigVersion is the config-version string Puppet stamped this
+	// run with, and PuppetVersion is the version of the puppet-agent
+	// which produced it.  Both come from the `version` block.
+	//
+	ConfigVersion string
+	PuppetVersion string
+
+	//
+	// Times holds every per-phase duration from the `time` block of
+	// the summary, keyed by phase name - config_retrieval,
+	// catalog_application, fact_generation, plugin_sync,
+	// transaction_evaluation, filebucket, etc.  This is the main
+	// reason these summary files exist, and the single "Runtime"
+	// figure above hides it.
+	//
+	Times map[string]float64
+
 	//
 	// Log messages.
 	//
@@ -93,13 +147,70 @@ type PuppetReport struct {
 	ResourcesSkipped []Resource
 	ResourcesOK      []Resource
 
+	//
+	// ResourcesDependencyFailed holds resources which Puppet skipped
+	// because one of their dependencies failed, as distinct from
+	// ResourcesSkipped which holds resources that were intentionally
+	// skipped via schedule metadata.  Triaging a red dashboard needs
+	// to tell these apart.
+	//
+	ResourcesDependencyFailed []Resource
+
 	//
 	// Hash of the report-body.
 	//
 	// This is used to create the file to store the report in on-disk,
-	// and as a means of detecting duplication submissions.
+	// and as a means of detecting duplication submissions - though
+	// TransactionUUID, when the submitting agent gave us one, is the
+	// more reliable signal; see DedupeKey.
 	//
 	Hash string
+
+	//
+	// ReportFormat is the `report_format` version of the schema this
+	// report was parsed as, e.g. 4 or 10.  It is 0 for reports which
+	// came in as the unversioned `last_run_summary.yaml` layout.
+	//
+	ReportFormat int
+
+	//
+	// CatalogUUID, JobID, TransactionUUID, CodeID, Noop and
+	// NoopPending are only populated for `report_format` 10+ reports.
+	//
+	// TransactionUUID in particular identifies a single puppet-run
+	// uniquely, even across re-transmissions of the same run that
+	// differ only in whitespace - which a SHA1 of the raw bytes
+	// wouldn't catch.
+	//
+	CatalogUUID     string
+	JobID           string
+	TransactionUUID string
+	CodeID          string
+	Noop            bool
+	NoopPending     bool
+
+	//
+	// HasCorrectiveChange is Puppet's own top-level verdict on whether
+	// this run contained any corrective changes, as distinct from the
+	// per-resource Resource.Corrective flag and the CorrectiveChange
+	// count above.
+	//
+	HasCorrectiveChange bool
+}
+
+//
+// DedupeKey returns the fingerprint to use when detecting whether this
+// report duplicates one we've already stored.  The TransactionUUID is
+// preferred, since Puppet can retransmit an identical run with a
+// whitespace-different serialisation that a SHA1 of the bytes wouldn't
+// recognise as the same thing; Hash is used as a fallback for schemas
+// which don't carry a transaction UUID.
+//
+func (p PuppetReport) DedupeKey() string {
+	if p.TransactionUUID != "" {
+		return p.TransactionUUID
+	}
+	return p.Hash
 }
 
 //
@@ -110,6 +221,42 @@ type PuppetReport struct {
 // set of code in the ParsePuppetReport method.
 //
 
+//
+// hostRegexp and environmentRegexp are the security checks applied to
+// the `host` and `environment` fields, regardless of whether the report
+// was submitted as YAML or JSON.
+//
+var hostRegexp = regexp.MustCompile("^([a-z0-9._-]+)$")
+var environmentRegexp = regexp.MustCompile("^([A-Za-z0-9_]+)$")
+
+//
+// validateHost ensures the given hostname passes our simple regexp, and
+// stores it in the report-structure if it does.
+//
+func validateHost(host string, out *PuppetReport) error {
+	if !hostRegexp.MatchString(host) {
+		metrics.ParseError("validateHost")
+		return errors.New("the submitted 'host' field failed our security check")
+	}
+
+	out.Fqdn = host
+	return nil
+}
+
+//
+// validateEnvironment ensures the given environment passes our simple
+// regexp, and stores it in the report-structure if it does.
+//
+func validateEnvironment(env string, out *PuppetReport) error {
+	if !environmentRegexp.MatchString(env) {
+		metrics.ParseError("validateEnvironment")
+		return errors.New("the submitted 'environment' field failed our security check")
+	}
+
+	out.Environment = env
+	return nil
+}
+
 //
 // parseHost reads the `host` parameter from the YAML and populates
 // the given report-structure with suitable values.
@@ -120,19 +267,11 @@ func parseHost(y *simpleyaml.Yaml, out *PuppetReport) error {
 	//
 	host, err := y.Get("host").String()
 	if err != nil {
+		metrics.ParseError("parseHost")
 		return errors.New("failed to get 'host' from YAML")
 	}
 
-	//
-	// Ensure the hostname passes a simple regexp
-	//
-	reg, _ := regexp.Compile("^([a-z0-9._-]+)$")
-	if !reg.MatchString(host) {
-		return errors.New("the submitted 'host' field failed our security check")
-	}
-
-	out.Fqdn = host
-	return nil
+	return validateHost(host, out)
 }
 
 //
@@ -145,19 +284,11 @@ func parseEnvironment(y *simpleyaml.Yaml, out *PuppetReport) error {
 	//
 	env, err := y.Get("environment").String()
 	if err != nil {
+		metrics.ParseError("parseEnvironment")
 		return errors.New("failed to get 'environment' from YAML")
 	}
 
-	//
-	// Ensure the hostname passes a simple regexp
-	//
-	reg, _ := regexp.Compile("^([A-Za-z0-9_]+)$")
-	if !reg.MatchString(env) {
-		return errors.New("the submitted 'environment' field failed our security check")
-	}
-
-	out.Environment = env
-	return nil
+	return validateEnvironment(env, out)
 }
 
 //
@@ -171,6 +302,7 @@ func parseTime(y *simpleyaml.Yaml, out *PuppetReport) error {
 	//
 	at, err := y.Get("time").String()
 	if err != nil {
+		metrics.ParseError("parseTime")
 		return errors.New("failed to get 'time' from YAML")
 	}
 
@@ -202,6 +334,7 @@ func parseStatus(y *simpleyaml.Yaml, out *PuppetReport) error {
 	//
 	state, err := y.Get("status").String()
 	if err != nil {
+		metrics.ParseError("parseStatus")
 		return errors.New("failed to get 'status' from YAML")
 	}
 
@@ -210,6 +343,7 @@ func parseStatus(y *simpleyaml.Yaml, out *PuppetReport) error {
 	case "unchanged":
 	case "failed":
 	default:
+		metrics.ParseError("parseStatus")
 		return errors.New("unexpected 'status' - " + state)
 	}
 
@@ -228,6 +362,7 @@ func parseRuntime(y *simpleyaml.Yaml, out *PuppetReport) error {
 	//
 	times, err := y.Get("metrics").Get("time").Get("values").Array()
 	if err != nil {
+		metrics.ParseError("parseRuntime")
 		return err
 	}
 
@@ -259,18 +394,43 @@ func parseResources(y *simpleyaml.Yaml, out *PuppetReport) error {
 
 	resources, err := y.Get("metrics").Get("resources").Get("values").Array()
 	if err != nil {
+		metrics.ParseError("parseResources")
 		return err
 	}
 
+	return populateResourceCounts(resources, out)
+}
+
+//
+// populateResourceCounts extracts the counts of resources which have
+// been failed, changed, skipped, etc, from an already-decoded array of
+// `metrics.resources.values` and updates the given report-structure
+// with those values.
+//
+// This is shared between the YAML and JSON parsing paths, since both
+// produce the same `[]interface{}` shape once decoded.
+//
+func populateResourceCounts(resources []interface{}, out *PuppetReport) error {
+
 	tr, _ := regexp.Compile("Total ([0-9.]+)")
 	fr, _ := regexp.Compile("Failed ([0-9.]+)")
 	sr, _ := regexp.Compile("Skipped ([0-9.]+)")
 	cr, _ := regexp.Compile("Changed ([0-9.]+)")
+	oos, _ := regexp.Compile("Out of sync ([0-9.]+)")
+	rsr, _ := regexp.Compile("Restarted ([0-9.]+)")
+	sch, _ := regexp.Compile("Scheduled ([0-9.]+)")
+	ftr, _ := regexp.Compile("Failed to restart ([0-9.]+)")
+	cch, _ := regexp.Compile("Corrective change ([0-9.]+)")
 
 	total := ""
 	changed := ""
 	failed := ""
 	skipped := ""
+	outOfSync := ""
+	restarted := ""
+	scheduled := ""
+	failedToRestart := ""
+	correctiveChange := ""
 
 	//
 	// HORRID: Help me, I'm in hell.
@@ -278,28 +438,181 @@ func parseResources(y *simpleyaml.Yaml, out *PuppetReport) error {
 	// TODO: Improve via reflection as per log-handling.
 	//
 	for _, value := range resources {
-		mr := tr.FindStringSubmatch(fmt.Sprint(value))
+		str := fmt.Sprint(value)
+
+		mr := tr.FindStringSubmatch(str)
 		if len(mr) == 2 {
 			total = mr[1]
 		}
-		mf := fr.FindStringSubmatch(fmt.Sprint(value))
+		mf := fr.FindStringSubmatch(str)
 		if len(mf) == 2 {
 			failed = mf[1]
 		}
-		ms := sr.FindStringSubmatch(fmt.Sprint(value))
+		ms := sr.FindStringSubmatch(str)
 		if len(ms) == 2 {
 			skipped = ms[1]
 		}
-		mc := cr.FindStringSubmatch(fmt.Sprint(value))
+		mc := cr.FindStringSubmatch(str)
 		if len(mc) == 2 {
 			changed = mc[1]
 		}
+		mo := oos.FindStringSubmatch(str)
+		if len(mo) == 2 {
+			outOfSync = mo[1]
+		}
+		mre := rsr.FindStringSubmatch(str)
+		if len(mre) == 2 {
+			restarted = mre[1]
+		}
+		msc := sch.FindStringSubmatch(str)
+		if len(msc) == 2 {
+			scheduled = msc[1]
+		}
+		mft := ftr.FindStringSubmatch(str)
+		if len(mft) == 2 {
+			failedToRestart = mft[1]
+		}
+		mcc := cch.FindStringSubmatch(str)
+		if len(mcc) == 2 {
+			correctiveChange = mcc[1]
+		}
 	}
 
 	out.Total = total
 	out.Changed = changed
 	out.Failed = failed
 	out.Skipped = skipped
+	out.OutOfSync = outOfSync
+	out.Restarted = restarted
+	out.ScheduledCount = scheduled
+	out.FailedToRestart = failedToRestart
+	out.CorrectiveChange = correctiveChange
+	return nil
+}
+
+//
+// parseEvents looks for the counts of events which succeeded or failed
+// during this run, and updates the given report-structure with those
+// values.
+//
+func parseEvents(y *simpleyaml.Yaml, out *PuppetReport) error {
+
+	events, err := y.Get("metrics").Get("events").Get("values").Array()
+	if err != nil {
+		metrics.ParseError("parseEvents")
+		return err
+	}
+
+	return populateEventCounts(events, out)
+}
+
+//
+// populateEventCounts extracts the counts of events which succeeded or
+// failed from an already-decoded array of `metrics.events.values` and
+// updates the given report-structure with those values.
+//
+// This is shared between the YAML and JSON parsing paths, since both
+// produce the same `[]interface{}` shape once decoded.
+//
+func populateEventCounts(events []interface{}, out *PuppetReport) error {
+
+	sr, _ := regexp.Compile("Success ([0-9.]+)")
+	fr, _ := regexp.Compile("Failure ([0-9.]+)")
+	tr, _ := regexp.Compile("Total ([0-9.]+)")
+
+	success := ""
+	failure := ""
+	total := ""
+
+	for _, value := range events {
+		str := fmt.Sprint(value)
+
+		ms := sr.FindStringSubmatch(str)
+		if len(ms) == 2 {
+			success = ms[1]
+		}
+		mf := fr.FindStringSubmatch(str)
+		if len(mf) == 2 {
+			failure = mf[1]
+		}
+		mt := tr.FindStringSubmatch(str)
+		if len(mt) == 2 {
+			total = mt[1]
+		}
+	}
+
+	out.EventsSuccess = success
+	out.EventsFailure = failure
+	out.EventsTotal = total
+	return nil
+}
+
+//
+// parseVersions reads the `version` block from the YAML and populates
+// the given report-structure with the config-version and puppet-agent
+// version strings it contains.
+//
+func parseVersions(y *simpleyaml.Yaml, out *PuppetReport) error {
+
+	config, cErr := y.Get("version").Get("config").String()
+	if cErr == nil {
+		out.ConfigVersion = config
+	}
+
+	puppet, pErr := y.Get("version").Get("puppet").String()
+	if pErr == nil {
+		out.PuppetVersion = puppet
+	}
+
+	return nil
+}
+
+//
+// parseTimesMap reads the `metrics.time.values` parameters from the YAML
+// and populates the given report-structure with a map of every per-phase
+// duration it finds, keyed by phase name.
+//
+func parseTimesMap(y *simpleyaml.Yaml, out *PuppetReport) error {
+
+	times, err := y.Get("metrics").Get("time").Get("values").Array()
+	if err != nil {
+		metrics.ParseError("parseTimesMap")
+		return err
+	}
+
+	return populateTimesMap(times, out)
+}
+
+//
+// populateTimesMap extracts every per-phase duration from an
+// already-decoded array of `metrics.time.values` and updates the given
+// report-structure with a map keyed by phase name.
+//
+// This is shared between the YAML and JSON parsing paths, since both
+// produce the same `[]interface{}` shape once decoded.
+//
+func populateTimesMap(times []interface{}, out *PuppetReport) error {
+
+	// Each entry looks like `[config_retrieval Config retrieval 1.23]` -
+	// a machine name, a human-readable label of one or more words, and
+	// the duration itself - so we only anchor on the first and last
+	// fields and ignore the label in between.
+	r, _ := regexp.Compile(`^\[([a-z_]+) .+ ([0-9.]+)\]$`)
+
+	phases := make(map[string]float64)
+
+	for _, value := range times {
+		match := r.FindStringSubmatch(fmt.Sprint(value))
+		if len(match) == 3 {
+			var f float64
+			_, sErr := fmt.Sscanf(match[2], "%f", &f)
+			if sErr == nil {
+				phases[match[1]] = f
+			}
+		}
+	}
+
+	out.Times = phases
 	return nil
 }
 
@@ -309,6 +622,7 @@ func parseResources(y *simpleyaml.Yaml, out *PuppetReport) error {
 func parseLogs(y *simpleyaml.Yaml, out *PuppetReport) error {
 	logs, err := y.Get("logs").Array()
 	if err != nil {
+		metrics.ParseError("parseLogs")
 		return errors.New("failed to get 'logs' from YAML")
 	}
 
@@ -339,6 +653,25 @@ func parseLogs(y *simpleyaml.Yaml, out *PuppetReport) error {
 	return nil
 }
 
+//
+// formatContainmentPath renders a resource's `containment_path` - a
+// list of the classes/resources it's nested within - the same way on
+// both the YAML and JSON parsing paths, so Resource.Reason reads
+// identically regardless of which format a report was submitted as.
+//
+func formatContainmentPath(path interface{}) string {
+	v := reflect.ValueOf(path)
+	if v.Kind() != reflect.Slice {
+		return fmt.Sprint(path)
+	}
+
+	parts := make([]string, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		parts = append(parts, fmt.Sprint(v.Index(i).Interface()))
+	}
+	return strings.Join(parts, " -> ")
+}
+
 //
 // parseResults updates the given report with details of any resource
 // which was failed, changed, or skipped.
@@ -346,18 +679,21 @@ func parseLogs(y *simpleyaml.Yaml, out *PuppetReport) error {
 func parseResults(y *simpleyaml.Yaml, out *PuppetReport) error {
 	rs, err := y.Get("resource_statuses").Map()
 	if err != nil {
+		metrics.ParseError("parseResults")
 		return errors.New("failed to get 'resource_statuses' from YAML")
 	}
 
 	var failed []Resource
 	var changed []Resource
 	var skipped []Resource
+	var dependencyFailed []Resource
 	var ok []Resource
 
 	for _, v2 := range rs {
 
 		// create a map here.
 		m := make(map[string]string)
+		var containmentPath interface{}
 
 		v := reflect.ValueOf(v2)
 		if v.Kind() == reflect.Map {
@@ -366,50 +702,55 @@ func parseResults(y *simpleyaml.Yaml, out *PuppetReport) error {
 
 				// Store the key/val in the map.
 				key, val := key.Interface(), strct.Interface()
+				if key.(string) == "containment_path" {
+					containmentPath = val
+				}
 				m[key.(string)] = fmt.Sprint(val)
 			}
 		}
 
-		// Now we should be able to look for skipped ones.
-		if m["skipped"] == "true" {
-			skipped = append(skipped,
-				Resource{Name: m["title"],
-					Type: m["resource_type"],
-					File: m["file"],
-					Line: m["line"]})
+		corrective := m["corrective_change"] == "true"
+
+		res := Resource{
+			Name:       m["title"],
+			Type:       m["resource_type"],
+			File:       m["file"],
+			Line:       m["line"],
+			Corrective: corrective,
+		}
+
+		// A resource that was skipped because a prerequisite of its
+		// own failed is reported separately from one that was simply
+		// never scheduled to run - the former points at a real
+		// failure elsewhere, the latter doesn't.
+		if m["skipped"] == "true" && m["dependency_failed"] == "true" {
+			res.Reason = "dependency failed: " + formatContainmentPath(containmentPath)
+			dependencyFailed = append(dependencyFailed, res)
+		} else if m["skipped"] == "true" {
+			res.Reason = "skipped"
+			skipped = append(skipped, res)
 		}
 
 		// Now we should be able to look for skipped ones.
 		if m["changed"] == "true" {
-			changed = append(changed,
-				Resource{Name: m["title"],
-					Type: m["resource_type"],
-					File: m["file"],
-					Line: m["line"]})
+			changed = append(changed, res)
 		}
 
 		// Now we should be able to look for skipped ones.
 		if m["failed"] == "true" {
-			failed = append(failed,
-				Resource{Name: m["title"],
-					Type: m["resource_type"],
-					File: m["file"],
-					Line: m["line"]})
+			failed = append(failed, res)
 		}
 
 		if m["failed"] == "false" &&
 			m["skipped"] == "false" &&
 			m["changed"] == "false" {
-			ok = append(ok,
-				Resource{Name: m["title"],
-					Type: m["resource_type"],
-					File: m["file"],
-					Line: m["line"]})
+			ok = append(ok, res)
 		}
 
 	}
 
 	out.ResourcesSkipped = skipped
+	out.ResourcesDependencyFailed = dependencyFailed
 	out.ResourcesFailed = failed
 	out.ResourcesChanged = changed
 	out.ResourcesOK = ok
@@ -418,15 +759,300 @@ func parseResults(y *simpleyaml.Yaml, out *PuppetReport) error {
 
 }
 
+//
+// Parser is implemented by each of the report schemas we understand.
+// Detect is given the raw, as-submitted bytes and should report - cheaply
+// and without error - whether it recognises the schema; Parse does the
+// real work of turning those bytes into a PuppetReport.
+//
+type Parser interface {
+	Detect(content []byte) bool
+	Parse(content []byte) (PuppetReport, error)
+}
+
+//
+// parsers lists every schema we understand, in priority order.  The
+// first one whose Detect returns true wins, so the more specific
+// JSON schemas are listed ahead of the two YAML layouts.
+//
+var parsers = []Parser{
+	reportFormat10Parser{},
+	reportFormat4Parser{},
+	transactionYAMLParser{},
+	lastRunSummaryParser{},
+}
+
 //
 // ParsePuppetReport is our main function in this module.  Given an
-// array of bytes we read the input and produce a PuppetReport structure.
+// array of bytes we read the input and produce a PuppetReport structure,
+// trying each of our known schemas in turn until one claims the content.
+//
+func ParsePuppetReport(content []byte) (PuppetReport, error) {
+	for _, p := range parsers {
+		if p.Detect(content) {
+			return p.Parse(content)
+		}
+	}
+
+	metrics.ParseError("ParsePuppetReport")
+
+	var x PuppetReport
+	return x, errors.New("no parser recognised this report")
+}
+
+//
+// reportFormatPeek is used to cheaply read the `report_format` field of
+// a JSON report, without paying for a full decode, so Detect() can pick
+// the right Parser.
+//
+type reportFormatPeek struct {
+	ReportFormat int `json:"report_format"`
+}
+
+//
+// peekReportFormat returns the `report_format` of a JSON report, or 0 if
+// the content isn't JSON or doesn't carry that field.
+//
+func peekReportFormat(content []byte) int {
+	var p reportFormatPeek
+	if err := json.Unmarshal(content, &p); err != nil {
+		return 0
+	}
+	return p.ReportFormat
+}
+
+//
+// looksLikeJSON reports whether the first non-whitespace byte of content
+// is `{`.
+//
+func looksLikeJSON(content []byte) bool {
+	trimmed := bytes.TrimSpace(content)
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+//
+// transactionYAMLParser understands the full, unversioned YAML
+// transaction report Puppet's `store` report processor writes out (and
+// which the HTTP upload endpoint has always accepted) - it carries
+// `host`/`environment`/`status`/`logs`/`resource_statuses`, none of
+// which the simpler on-disk `last_run_summary.yaml` layout has.
+//
+type transactionYAMLParser struct{}
+
+func (transactionYAMLParser) Detect(content []byte) bool {
+	if looksLikeJSON(content) {
+		return false
+	}
+
+	y, err := simpleyaml.NewYaml(content)
+	if err != nil {
+		return false
+	}
+
+	_, err = y.Get("host").String()
+	return err == nil
+}
+
+func (transactionYAMLParser) Parse(content []byte) (PuppetReport, error) {
+	return parseYAMLReport(content)
+}
+
+//
+// lastRunSummaryParser understands the simpler, unversioned
+// `last_run_summary.yaml` layout Puppet agents write to disk after
+// every run.  Unlike the full transaction report it has no
+// `host`/`environment`/`status`/`logs`/`resource_statuses` keys at
+// all - just flat `version`, `time`, `resources` and `events` maps -
+// so the resulting PuppetReport carries no node identity; callers
+// ingesting this layout need to supply Fqdn/Environment themselves,
+// e.g. from the URL the file was uploaded against.
+//
+type lastRunSummaryParser struct{}
+
+func (lastRunSummaryParser) Detect(content []byte) bool {
+	if looksLikeJSON(content) {
+		return false
+	}
+
+	y, err := simpleyaml.NewYaml(content)
+	if err != nil {
+		return false
+	}
+
+	// This layout has no 'host' key, unlike the full transaction
+	// report - that's how we tell the two YAML schemas apart.
+	_, hostErr := y.Get("host").String()
+	if hostErr == nil {
+		return false
+	}
+
+	_, timeErr := y.Get("time").Map()
+	_, resourcesErr := y.Get("resources").Map()
+	return timeErr == nil && resourcesErr == nil
+}
+
+func (lastRunSummaryParser) Parse(content []byte) (PuppetReport, error) {
+	var x PuppetReport
+
+	y, err := simpleyaml.NewYaml(content)
+	if err != nil {
+		metrics.ParseError("lastRunSummaryParser")
+		return x, errors.New("failed to parse YAML")
+	}
+
+	helper := sha1.New()
+	helper.Write(content)
+	x.Hash = fmt.Sprintf("%x", helper.Sum(nil))
+
+	// The 'version' block is shaped the same way as the transaction
+	// report's, so the existing parser works unmodified.
+	if err := parseVersions(y, &x); err != nil {
+		return x, err
+	}
+
+	// 'time' here is a flat map of phase -> seconds, not the
+	// metrics.time.values tuples the transaction report uses.
+	times, err := y.Get("time").Map()
+	if err != nil {
+		metrics.ParseError("lastRunSummaryParser")
+		return x, errors.New("failed to get 'time' from YAML")
+	}
+	phases := make(map[string]float64)
+	for k, v := range times {
+		key, ok := k.(string)
+		if !ok {
+			continue
+		}
+		var f float64
+		if _, sErr := fmt.Sscanf(fmt.Sprint(v), "%f", &f); sErr == nil {
+			phases[key] = f
+		}
+	}
+	x.Times = phases
+	if total, ok := phases["total"]; ok {
+		x.Runtime = fmt.Sprintf("%v", total)
+	}
+
+	// 'resources' here is a flat map of counts, not the
+	// metrics.resources.values tuples the transaction report uses.
+	resources, err := y.Get("resources").Map()
+	if err != nil {
+		metrics.ParseError("lastRunSummaryParser")
+		return x, errors.New("failed to get 'resources' from YAML")
+	}
+	x.Total = lastRunSummaryMapString(resources, "total")
+	x.Changed = lastRunSummaryMapString(resources, "changed")
+	x.Failed = lastRunSummaryMapString(resources, "failed")
+	x.Skipped = lastRunSummaryMapString(resources, "skipped")
+	x.OutOfSync = lastRunSummaryMapString(resources, "out_of_sync")
+	x.Restarted = lastRunSummaryMapString(resources, "restarted")
+	x.ScheduledCount = lastRunSummaryMapString(resources, "scheduled")
+	x.FailedToRestart = lastRunSummaryMapString(resources, "failed_to_restart")
+	x.CorrectiveChange = lastRunSummaryMapString(resources, "corrective_change")
+
+	// 'events' is optional - older Puppet versions don't write it.
+	events, err := y.Get("events").Map()
+	if err == nil {
+		x.EventsSuccess = lastRunSummaryMapString(events, "success")
+		x.EventsFailure = lastRunSummaryMapString(events, "failure")
+		x.EventsTotal = lastRunSummaryMapString(events, "total")
+	}
+
+	return x, nil
+}
+
+//
+// lastRunSummaryMapString returns the string form of m[key], or "" if
+// the key isn't present.
+//
+func lastRunSummaryMapString(m map[interface{}]interface{}, key string) string {
+	v, ok := m[key]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+//
+// reportFormat4Parser understands the JSON report Puppet produces with
+// `report_format` 4 - the schema our JSON support originally targeted.
+//
+type reportFormat4Parser struct{}
+
+func (reportFormat4Parser) Detect(content []byte) bool {
+	return looksLikeJSON(content) && peekReportFormat(content) < 10
+}
+
+func (reportFormat4Parser) Parse(content []byte) (PuppetReport, error) {
+	x, err := parseJSONReport(content)
+	if err != nil {
+		return x, err
+	}
+
+	x.ReportFormat = 4
+	return x, nil
+}
+
+//
+// reportFormat10Parser understands the richer JSON report schema Puppet
+// moved to at `report_format` 10, which adds `catalog_uuid`, `job_id`,
+// `transaction_uuid`, `code_id`, `noop`, `noop_pending` and a top-level
+// `corrective_change` flag on top of the format-4 fields.
+//
+type reportFormat10Parser struct{}
+
+func (reportFormat10Parser) Detect(content []byte) bool {
+	return looksLikeJSON(content) && peekReportFormat(content) >= 10
+}
+
+//
+// jsonReportFormat10Extra holds the fields `report_format` 10 added on
+// top of the format-4 schema.
+//
+type jsonReportFormat10Extra struct {
+	ReportFormat     int    `json:"report_format"`
+	CatalogUUID      string `json:"catalog_uuid"`
+	JobID            string `json:"job_id"`
+	TransactionUUID  string `json:"transaction_uuid"`
+	CodeID           string `json:"code_id"`
+	Noop             bool   `json:"noop"`
+	NoopPending      bool   `json:"noop_pending"`
+	CorrectiveChange bool   `json:"corrective_change"`
+}
+
+func (reportFormat10Parser) Parse(content []byte) (PuppetReport, error) {
+	x, err := parseJSONReport(content)
+	if err != nil {
+		return x, err
+	}
+
+	var extra jsonReportFormat10Extra
+	if err := json.Unmarshal(content, &extra); err != nil {
+		metrics.ParseError("reportFormat10Parser")
+		return x, errors.New("failed to parse JSON")
+	}
+
+	x.ReportFormat = extra.ReportFormat
+	x.CatalogUUID = extra.CatalogUUID
+	x.JobID = extra.JobID
+	x.TransactionUUID = extra.TransactionUUID
+	x.CodeID = extra.CodeID
+	x.Noop = extra.Noop
+	x.NoopPending = extra.NoopPending
+	x.HasCorrectiveChange = extra.CorrectiveChange
+
+	return x, nil
+}
+
+//
+// parseYAMLReport is our original parser.  Given an array of bytes we
+// read the input and produce a PuppetReport structure.
 //
 // Various (simple) error conditions are handled to ensure that the result
 // is somewhat safe - for example we must have some fields such as
 // `hostname`, `time`, etc.
 //
-func ParsePuppetReport(content []byte) (PuppetReport, error) {
+func parseYAMLReport(content []byte) (PuppetReport, error) {
 	//
 	// The return-value.
 	//
@@ -437,6 +1063,7 @@ func ParsePuppetReport(content []byte) (PuppetReport, error) {
 	//
 	yaml, err := simpleyaml.NewYaml(content)
 	if err != nil {
+		metrics.ParseError("parseYAMLReport")
 		return x, errors.New("failed to parse YAML")
 	}
 
@@ -495,6 +1122,30 @@ func ParsePuppetReport(content []byte) (PuppetReport, error) {
 		return x, resourcesError
 	}
 
+	//
+	// Parse the events block
+	//
+	eventsError := parseEvents(yaml, &x)
+	if eventsError != nil {
+		return x, eventsError
+	}
+
+	//
+	// Parse the version block
+	//
+	versionsError := parseVersions(yaml, &x)
+	if versionsError != nil {
+		return x, versionsError
+	}
+
+	//
+	// Parse the per-phase timings
+	//
+	timesError := parseTimesMap(yaml, &x)
+	if timesError != nil {
+		return x, timesError
+	}
+
 	//
 	// Get the logs from this run
 	//
@@ -513,3 +1164,208 @@ func ParsePuppetReport(content []byte) (PuppetReport, error) {
 
 	return x, nil
 }
+
+//
+// jsonMetricSection mirrors one entry of the `metrics` block of a JSON
+// report - `values` is an array of `[name, label, value]` tuples, the
+// same shape `simpleyaml`'s `Array()` hands us for the YAML path.
+//
+type jsonMetricSection struct {
+	Values []interface{} `json:"values"`
+}
+
+//
+// jsonMetrics mirrors the `metrics` block of a JSON report.
+//
+type jsonMetrics struct {
+	Time      jsonMetricSection `json:"time"`
+	Resources jsonMetricSection `json:"resources"`
+	Events    jsonMetricSection `json:"events"`
+}
+
+//
+// jsonVersion mirrors the `version` block of a JSON report.
+//
+type jsonVersion struct {
+	Config string `json:"config"`
+	Puppet string `json:"puppet"`
+}
+
+//
+// jsonLogEntry mirrors a single entry of the `logs` array of a JSON
+// report.
+//
+type jsonLogEntry struct {
+	Source  string `json:"source"`
+	Message string `json:"message"`
+}
+
+//
+// jsonResourceStatus mirrors a single value of the `resource_statuses`
+// map of a JSON report.
+//
+type jsonResourceStatus struct {
+	Title            string      `json:"title"`
+	ResourceType     string      `json:"resource_type"`
+	File             string      `json:"file"`
+	Line             jsonLineNum `json:"line"`
+	Skipped          bool        `json:"skipped"`
+	Changed          bool        `json:"changed"`
+	Failed           bool        `json:"failed"`
+	DependencyFailed bool        `json:"dependency_failed"`
+	CorrectiveChange bool        `json:"corrective_change"`
+	ContainmentPath  []string    `json:"containment_path"`
+}
+
+//
+// jsonLineNum decodes the `line` field of a resource-status, which real
+// Puppet JSON reports emit as a number (or null) rather than the string
+// our own struct tags would otherwise demand.  We still want it as a
+// plain string on Resource, matching what the YAML/reflection path
+// already produces via fmt.Sprint, so we accept either shape here.
+//
+type jsonLineNum string
+
+func (l *jsonLineNum) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*l = ""
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = jsonLineNum(s)
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*l = jsonLineNum(n.String())
+	return nil
+}
+
+//
+// jsonPuppetReport is the typed intermediate struct we decode a JSON
+// report into, before copying its fields across to a PuppetReport.
+//
+type jsonPuppetReport struct {
+	Host             string                        `json:"host"`
+	Environment      string                        `json:"environment"`
+	Status           string                        `json:"status"`
+	Time             string                        `json:"time"`
+	Metrics          jsonMetrics                   `json:"metrics"`
+	Version          jsonVersion                   `json:"version"`
+	Logs             []jsonLogEntry                `json:"logs"`
+	ResourceStatuses map[string]jsonResourceStatus `json:"resource_statuses"`
+}
+
+//
+// parseJSONReport decodes a JSON-serialised Puppet report directly into
+// a PuppetReport, using `encoding/json` and the typed structs above
+// rather than the reflection-based walk the YAML path needs to cope
+// with `simpleyaml`'s untyped maps.
+//
+func parseJSONReport(content []byte) (PuppetReport, error) {
+	var x PuppetReport
+
+	var jr jsonPuppetReport
+	if err := json.Unmarshal(content, &jr); err != nil {
+		metrics.ParseError("parseJSONReport")
+		return x, errors.New("failed to parse JSON")
+	}
+
+	//
+	// Store the SHA1-hash of the report contents
+	//
+	helper := sha1.New()
+	helper.Write(content)
+	x.Hash = fmt.Sprintf("%x", helper.Sum(nil))
+
+	if err := validateHost(jr.Host, &x); err != nil {
+		return x, err
+	}
+
+	if err := validateEnvironment(jr.Environment, &x); err != nil {
+		return x, err
+	}
+
+	switch jr.Status {
+	case "changed":
+	case "unchanged":
+	case "failed":
+	default:
+		metrics.ParseError("parseJSONReport")
+		return x, errors.New("unexpected 'status' - " + jr.Status)
+	}
+	x.State = jr.Status
+
+	x.At = strings.Replace(jr.Time, "'", "", -1)
+
+	r, _ := regexp.Compile("Total ([0-9.]+)")
+	for _, value := range jr.Metrics.Time.Values {
+		match := r.FindStringSubmatch(fmt.Sprint(value))
+		if len(match) == 2 {
+			x.Runtime = match[1]
+		}
+	}
+
+	if err := populateResourceCounts(jr.Metrics.Resources.Values, &x); err != nil {
+		return x, err
+	}
+
+	if err := populateEventCounts(jr.Metrics.Events.Values, &x); err != nil {
+		return x, err
+	}
+
+	if err := populateTimesMap(jr.Metrics.Time.Values, &x); err != nil {
+		return x, err
+	}
+
+	x.ConfigVersion = jr.Version.Config
+	x.PuppetVersion = jr.Version.Puppet
+
+	var logged []string
+	for _, l := range jr.Logs {
+		if len(l.Message) > 0 {
+			logged = append(logged, l.Source+" : "+l.Message)
+		}
+	}
+	x.LogMessages = logged
+
+	var failed, changed, skipped, dependencyFailed, ok []Resource
+	for _, rs := range jr.ResourceStatuses {
+		res := Resource{
+			Name:       rs.Title,
+			Type:       rs.ResourceType,
+			File:       rs.File,
+			Line:       string(rs.Line),
+			Corrective: rs.CorrectiveChange,
+		}
+
+		if rs.Skipped && rs.DependencyFailed {
+			res.Reason = "dependency failed: " + strings.Join(rs.ContainmentPath, " -> ")
+			dependencyFailed = append(dependencyFailed, res)
+		} else if rs.Skipped {
+			res.Reason = "skipped"
+			skipped = append(skipped, res)
+		}
+		if rs.Changed {
+			changed = append(changed, res)
+		}
+		if rs.Failed {
+			failed = append(failed, res)
+		}
+		if !rs.Failed && !rs.Skipped && !rs.Changed {
+			ok = append(ok, res)
+		}
+	}
+	x.ResourcesSkipped = skipped
+	x.ResourcesDependencyFailed = dependencyFailed
+	x.ResourcesFailed = failed
+	x.ResourcesChanged = changed
+	x.ResourcesOK = ok
+
+	return x, nil
+}
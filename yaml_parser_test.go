@@ -0,0 +1,211 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/smallfish/simpleyaml"
+)
+
+// TestPopulateTimesMap confirms populateTimesMap anchors on the first and
+// last fields of a metrics.time.values tuple rather than assuming a
+// 2-token [name value] shape - real Puppet output is 3-token
+// [name label value], and a regex expecting only 2 tokens silently drops
+// every phase duration.
+func TestPopulateTimesMap(t *testing.T) {
+	times := []interface{}{
+		[]interface{}{"config_retrieval", "Config retrieval", 1.23},
+		[]interface{}{"catalog_application", "Catalog application", 4.56},
+	}
+
+	var report PuppetReport
+	if err := populateTimesMap(times, &report); err != nil {
+		t.Fatalf("populateTimesMap returned an error: %s", err)
+	}
+
+	if got := report.Times["config_retrieval"]; got != 1.23 {
+		t.Errorf("config_retrieval = %v, want 1.23", got)
+	}
+	if got := report.Times["catalog_application"]; got != 4.56 {
+		t.Errorf("catalog_application = %v, want 4.56", got)
+	}
+}
+
+// TestJSONLineNumAcceptsNumberStringOrNull confirms jsonLineNum decodes
+// a resource-status `line` field regardless of whether Puppet sent it
+// as a JSON number, a string, or null - real Puppet JSON reports emit a
+// number, which a plain `string` struct tag rejects outright.
+func TestJSONLineNumAcceptsNumberStringOrNull(t *testing.T) {
+	cases := []struct {
+		json string
+		want string
+	}{
+		{`42`, "42"},
+		{`"42"`, "42"},
+		{`null`, ""},
+	}
+
+	for _, c := range cases {
+		var l jsonLineNum
+		if err := l.UnmarshalJSON([]byte(c.json)); err != nil {
+			t.Errorf("UnmarshalJSON(%s) returned an error: %s", c.json, err)
+			continue
+		}
+		if string(l) != c.want {
+			t.Errorf("UnmarshalJSON(%s) = %q, want %q", c.json, string(l), c.want)
+		}
+	}
+}
+
+// TestParseJSONReportNumericLine confirms a full JSON report whose
+// resource-status `line` field is a number, as real Puppet agents send,
+// parses rather than failing json.Unmarshal.
+func TestParseJSONReportNumericLine(t *testing.T) {
+	content := `{
+		"host": "foo.example.com",
+		"environment": "production",
+		"status": "changed",
+		"time": "2023-04-05T10:11:12Z",
+		"metrics": {
+			"time": {"values": [["total", "Total", 1.0]]},
+			"resources": {"values": [["total", "Total", 1]]},
+			"events": {"values": [["total", "Total", 0]]}
+		},
+		"version": {"config": "1", "puppet": "7.10.0"},
+		"logs": [],
+		"resource_statuses": {
+			"File[/etc/foo]": {
+				"title": "/etc/foo",
+				"resource_type": "File",
+				"file": "/etc/puppet/modules/foo/manifests/init.pp",
+				"line": 10,
+				"skipped": false,
+				"changed": true,
+				"failed": false,
+				"dependency_failed": false,
+				"corrective_change": false,
+				"containment_path": ["Foo", "File[/etc/foo]"]
+			}
+		}
+	}`
+
+	report, err := parseJSONReport([]byte(content))
+	if err != nil {
+		t.Fatalf("parseJSONReport returned an error: %s", err)
+	}
+
+	if len(report.ResourcesChanged) != 1 {
+		t.Fatalf("ResourcesChanged has %d entries, want 1", len(report.ResourcesChanged))
+	}
+	if got := report.ResourcesChanged[0].Line; got != "10" {
+		t.Errorf("Line = %q, want %q", got, "10")
+	}
+}
+
+// TestParseResultsSkippedVsDependencyFailed confirms parseResults keeps
+// a resource that was simply never scheduled (skipped) separate from
+// one that was skipped because a prerequisite of its own failed
+// (dependency_failed) - the former is routine, the latter points at a
+// real failure elsewhere, and lumping them together would hide that.
+// It also confirms the dependency-failed Reason is rendered via
+// formatContainmentPath rather than Go's default slice formatting.
+func TestParseResultsSkippedVsDependencyFailed(t *testing.T) {
+	content := []byte(`
+resource_statuses:
+  File[/etc/skipped]:
+    title: /etc/skipped
+    resource_type: File
+    file: /etc/puppet/modules/foo/manifests/init.pp
+    line: 1
+    skipped: true
+    dependency_failed: false
+    changed: false
+    failed: false
+    corrective_change: false
+  File[/etc/dependency]:
+    title: /etc/dependency
+    resource_type: File
+    file: /etc/puppet/modules/foo/manifests/init.pp
+    line: 2
+    skipped: true
+    dependency_failed: true
+    changed: false
+    failed: false
+    corrective_change: false
+    containment_path:
+      - Foo
+      - File[/etc/dependency]
+`)
+
+	y, err := simpleyaml.NewYaml(content)
+	if err != nil {
+		t.Fatalf("simpleyaml.NewYaml returned an error: %s", err)
+	}
+
+	var report PuppetReport
+	if err := parseResults(y, &report); err != nil {
+		t.Fatalf("parseResults returned an error: %s", err)
+	}
+
+	if len(report.ResourcesSkipped) != 1 {
+		t.Fatalf("ResourcesSkipped has %d entries, want 1", len(report.ResourcesSkipped))
+	}
+	if got := report.ResourcesSkipped[0].Name; got != "/etc/skipped" {
+		t.Errorf("ResourcesSkipped[0].Name = %q, want %q", got, "/etc/skipped")
+	}
+
+	if len(report.ResourcesDependencyFailed) != 1 {
+		t.Fatalf("ResourcesDependencyFailed has %d entries, want 1", len(report.ResourcesDependencyFailed))
+	}
+
+	want := "dependency failed: Foo -> File[/etc/dependency]"
+	if got := report.ResourcesDependencyFailed[0].Reason; got != want {
+		t.Errorf("ResourcesDependencyFailed[0].Reason = %q, want %q", got, want)
+	}
+}
+
+// TestParsePuppetReportDetectsSchema confirms ParsePuppetReport routes
+// each of the four report schemas we understand to the right Parser -
+// report_format distinguishing the two JSON schemas, and the presence
+// of a `host` key distinguishing the two YAML ones.
+func TestParsePuppetReportDetectsSchema(t *testing.T) {
+	cases := []struct {
+		name   string
+		parser Parser
+		ok     bool
+	}{
+		{"format4", reportFormat4Parser{}, true},
+		{"format10", reportFormat10Parser{}, true},
+		{"transactionYAML", transactionYAMLParser{}, true},
+		{"lastRunSummary", lastRunSummaryParser{}, true},
+	}
+
+	samples := map[string][]byte{
+		"format4":  []byte(`{"report_format": 4, "host": "foo.example.com"}`),
+		"format10": []byte(`{"report_format": 10, "host": "foo.example.com"}`),
+		"transactionYAML": []byte(`
+host: foo.example.com
+environment: production
+`),
+		"lastRunSummary": []byte(`
+version:
+  config: "1"
+  puppet: "7.10.0"
+time:
+  total: 1.0
+resources:
+  total: 1
+`),
+	}
+
+	for name, content := range samples {
+		var matched []string
+		for _, c := range cases {
+			if c.parser.Detect(content) {
+				matched = append(matched, c.name)
+			}
+		}
+		if len(matched) != 1 || matched[0] != name {
+			t.Errorf("%s sample matched parsers %v, want exactly [%s]", name, matched, name)
+		}
+	}
+}